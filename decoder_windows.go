@@ -1,25 +1,145 @@
 package registry
 
 import (
-	"net/url"
+	"errors"
+	"reflect"
 	"strings"
+	"syscall"
 )
 
+// Decoder holds an already-open handle to a registry key across
+// successive Decode/Encode/Watch calls, so the path it names is only
+// resolved once rather than on every call. Close releases whatever this
+// Decoder itself opened (a remote connection, an opened key, or both).
 type Decoder struct {
-	root string
-	path string
+	hkey     syscall.Handle
+	ownsHkey bool // true if hkey must be closed by Close
+
+	remoteRoot syscall.Handle
+	hasRemote  bool // true if remoteRoot came from RegConnectRegistry and must be closed
 }
 
 func NewDecoder(registryPath string) *Decoder {
-	regUrl, err := url.Parse(registryPath)
+	server, path, rootHkey, err := parseRegistryURL(registryPath)
+	if err != nil {
+		return nil
+	}
+
+	root, _, err := connectRoot(server, rootHkey)
 	if err != nil {
 		return nil
 	}
+	hasRemote := server != ""
 
-	path := strings.Replace(regUrl.Path[1:], `/`, `\`, -1)
+	d := &Decoder{
+		hkey:       root,
+		remoteRoot: root,
+		hasRemote:  hasRemote,
+	}
 
-	return &Decoder{
-		root: strings.ToLower(regUrl.Host),
-		path: path,
+	if path == "" {
+		return d
+	}
+
+	hkey, err := openSubkey(root, path)
+	if err != nil {
+		if hasRemote {
+			syscall.RegCloseKey(root)
+		}
+		return nil
+	}
+
+	d.hkey = hkey
+	d.ownsHkey = true
+	return d
+}
+
+// WithRoot returns a sub-decoder scoped to the child key sub, reusing
+// this Decoder's already-open handle as sub's parent instead of
+// reopening a path from the hive root.
+func (d *Decoder) WithRoot(sub string) *Decoder {
+	hkey, err := openSubkey(d.hkey, sub)
+	if err != nil {
+		return nil
+	}
+	return &Decoder{hkey: hkey, ownsHkey: true}
+}
+
+// Close releases whatever NewDecoder/WithRoot opened for this Decoder: the
+// key handle it holds, and, for a Decoder rooted at a remote machine, the
+// RegConnectRegistry connection underneath it.
+func (d *Decoder) Close() error {
+	if d.ownsHkey {
+		if err := syscall.RegCloseKey(d.hkey); err != nil {
+			return err
+		}
+	}
+	if d.hasRemote {
+		return syscall.RegCloseKey(d.remoteRoot)
+	}
+	return nil
+}
+
+// Decode unmarshals i, a struct (or pointer to one), from the key this
+// Decoder is rooted at. It may be called repeatedly, with different
+// struct types, without reopening that key.
+func (d *Decoder) Decode(i interface{}) error {
+	rval := reflect.ValueOf(i)
+	ent := entryFor(rval.Type(), "", &fieldInfo{required: true, anonymous: true})
+	if err := ent.populate(d.hkey); err != nil {
+		return err
+	}
+	return ent.unmarshal(rval)
+}
+
+// Encode writes i, a struct (or pointer to one), back to the key this
+// Decoder is rooted at. It is the symmetric counterpart to Decode.
+func (d *Decoder) Encode(i interface{}) error {
+	rval := reflect.ValueOf(i)
+	if rval.Kind() == reflect.Ptr {
+		rval = rval.Elem()
+	}
+
+	ent := entryFor(rval.Type(), "", &fieldInfo{required: true, anonymous: true})
+	return ent.marshal(rval, d.hkey)
+}
+
+// DecodeValue decodes a single named value into out (a pointer), without
+// requiring a whole struct to be declared for it. relPath, if non-empty,
+// names a subkey of this Decoder's root to look the value up under.
+func (d *Decoder) DecodeValue(relPath, valueName string, out interface{}) error {
+	hkey := d.hkey
+	if relPath != "" {
+		var err error
+		hkey, err = openSubkey(d.hkey, relPath)
+		if err != nil {
+			return err
+		}
+		defer syscall.RegCloseKey(hkey)
+	}
+
+	rv := &registryValue{field: &fieldInfo{name: valueName, required: true}, kind: -1}
+	if err := rv.populate(hkey); err != nil {
+		return err
+	}
+
+	rval := reflect.ValueOf(out)
+	if rval.Kind() != reflect.Ptr || rval.IsNil() {
+		return errors.New("registry: DecodeValue requires a non-nil pointer")
+	}
+	return rv.unmarshal(rval.Elem())
+}
+
+func openSubkey(parent syscall.Handle, path string) (syscall.Handle, error) {
+	pathU16, err := syscall.UTF16PtrFromString(strings.Replace(path, "/", `\`, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	var hkey syscall.Handle
+	err = syscall.RegOpenKeyEx(parent, pathU16, 0, syscall.KEY_READ|syscall.KEY_NOTIFY, &hkey)
+	if err != nil {
+		return 0, err
 	}
+	return hkey, nil
 }
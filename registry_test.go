@@ -3,6 +3,8 @@
 package registry
 
 import (
+	"reflect"
+	"syscall"
 	"testing"
 )
 
@@ -42,3 +44,161 @@ func TestNestedUnmarshal(t *testing.T) {
 	}
 	t.Logf("%+v", bs)
 }
+
+func TestParseRegistryURL(t *testing.T) {
+	cases := []struct {
+		url    string
+		server string
+		path   string
+		root   syscall.Handle
+	}{
+		{"//hklm/Software/Foo", "", `Software\Foo`, syscall.HKEY_LOCAL_MACHINE},
+		{"//hkcu/Software/Foo/Bar", "", `Software\Foo\Bar`, syscall.HKEY_CURRENT_USER},
+		{"//hklm/", "", "", syscall.HKEY_LOCAL_MACHINE},
+		{"//SERVER/hklm/Software/Foo", "SERVER", `Software\Foo`, syscall.HKEY_LOCAL_MACHINE},
+	}
+
+	for _, c := range cases {
+		server, path, root, err := parseRegistryURL(c.url)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.url, err)
+			continue
+		}
+		if server != c.server || path != c.path || root != c.root {
+			t.Errorf("%s: got (%q, %q, %v), want (%q, %q, %v)", c.url, server, path, root, c.server, c.path, c.root)
+		}
+	}
+}
+
+func TestParseRegistryURLUnknownRoot(t *testing.T) {
+	if _, _, _, err := parseRegistryURL("//bogus/Software/Foo"); err == nil {
+		t.Fatal("expected an error for an unknown root key")
+	}
+}
+
+func TestFieldToFieldInfo(t *testing.T) {
+	type tagged struct {
+		Plain      string
+		Named      string `registry:"CustomName"`
+		Required   string `registry:",required"`
+		Expand     string `registry:",expand"`
+		ModTime    string `registry:",modtime"`
+		ValueCount string `registry:",valuecount"`
+		Security   string `registry:",security"`
+		Skipped    string `registry:"-"`
+		unexported string
+	}
+
+	typ := reflect.TypeOf(tagged{})
+
+	if fi := fieldToFieldInfo(typ.Field(0)); fi.name != "Plain" {
+		t.Errorf("Plain: got name %q, want %q", fi.name, "Plain")
+	}
+	if fi := fieldToFieldInfo(typ.Field(1)); fi.name != "CustomName" {
+		t.Errorf("Named: got name %q, want %q", fi.name, "CustomName")
+	}
+	if fi := fieldToFieldInfo(typ.Field(2)); !fi.required {
+		t.Error("Required: fi.required = false, want true")
+	}
+	if fi := fieldToFieldInfo(typ.Field(3)); !fi.expand {
+		t.Error("Expand: fi.expand = false, want true")
+	}
+	if fi := fieldToFieldInfo(typ.Field(4)); !fi.modtime {
+		t.Error("ModTime: fi.modtime = false, want true")
+	}
+	if fi := fieldToFieldInfo(typ.Field(5)); !fi.valuecount {
+		t.Error("ValueCount: fi.valuecount = false, want true")
+	}
+	if fi := fieldToFieldInfo(typ.Field(6)); !fi.security {
+		t.Error("Security: fi.security = false, want true")
+	}
+	if fi := fieldToFieldInfo(typ.Field(7)); fi != nil {
+		t.Errorf("Skipped: fieldToFieldInfo = %+v, want nil", fi)
+	}
+	if fi := fieldToFieldInfo(typ.Field(8)); fi != nil {
+		t.Errorf("unexported: fieldToFieldInfo = %+v, want nil", fi)
+	}
+}
+
+// fakeCodec implements Unmarshaler on a pointer receiver and Marshaler on a
+// value receiver, so it exercises both halves of marshalerFor/unmarshalerFor.
+type fakeCodec struct {
+	kind uint32
+	data []byte
+}
+
+func (f *fakeCodec) UnmarshalRegistryValue(kind uint32, data []byte) error {
+	f.kind = kind
+	f.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (f fakeCodec) MarshalRegistryValue() (uint32, []byte, error) {
+	return f.kind, f.data, nil
+}
+
+func TestUnmarshalerFor(t *testing.T) {
+	var fc fakeCodec
+	val := reflect.ValueOf(&fc).Elem()
+
+	um, ok := unmarshalerFor(val)
+	if !ok {
+		t.Fatal("unmarshalerFor: Unmarshaler not found via addressable value")
+	}
+	if err := um.UnmarshalRegistryValue(syscall.REG_SZ, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if fc.kind != syscall.REG_SZ || string(fc.data) != "hi" {
+		t.Errorf("UnmarshalRegistryValue did not reach fc: got %+v", fc)
+	}
+
+	if _, ok := unmarshalerFor(reflect.ValueOf(fakeCodec{})); ok {
+		t.Error("unmarshalerFor: unexpectedly found Unmarshaler on a non-addressable value")
+	}
+}
+
+func TestMarshalerFor(t *testing.T) {
+	fc := fakeCodec{kind: syscall.REG_DWORD, data: []byte{1, 2, 3, 4}}
+	val := reflect.ValueOf(fc)
+
+	m, ok := marshalerFor(val, val)
+	if !ok {
+		t.Fatal("marshalerFor: Marshaler not found on a value receiver")
+	}
+	kind, data, err := m.MarshalRegistryValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != fc.kind || string(data) != string(fc.data) {
+		t.Errorf("MarshalRegistryValue() = (%v, %v), want (%v, %v)", kind, data, fc.kind, fc.data)
+	}
+}
+
+func TestCollectWatchPaths(t *testing.T) {
+	skippedSub := &registryKey{field: &fieldInfo{name: "Missing"}, path: "Missing", skip: true}
+	presentSub := &registryKey{field: &fieldInfo{name: "Present"}, path: "Present"}
+	root := &registryKey{
+		field:      &fieldInfo{required: true, anonymous: true},
+		subentries: []registryEntry{skippedSub, presentSub},
+	}
+
+	paths := collectWatchPaths(root, "")
+	want := []string{"", "Present"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("collectWatchPaths = %v, want %v", paths, want)
+	}
+}
+
+func TestUTF16UTF8RoundTrip(t *testing.T) {
+	cases := []string{"", "hello", "héllo wörld", "日本語"}
+	for _, s := range cases {
+		b := utf8ToUTF16Bytes(s)
+		if len(b)%2 != 0 {
+			t.Errorf("utf8ToUTF16Bytes(%q): odd length %d", s, len(b))
+		}
+		got := string(utf16BytesToUTF8(b[:len(b)-2]))
+		if got != s {
+			t.Errorf("utf16BytesToUTF8(utf8ToUTF16Bytes(%q)) = %q, want %q", s, got, s)
+		}
+	}
+}
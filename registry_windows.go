@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 	"unicode/utf16"
 	"unicode/utf8"
 )
@@ -18,8 +20,16 @@ import (
 type fieldInfo struct {
 	name      string
 	required  bool
+	expand    bool
 	index     []int
 	anonymous bool
+
+	// These mark a field as key metadata rather than a value or subkey:
+	// it's populated from the enclosing registryKey's own handle, via
+	// RegQueryInfoKey/RegGetKeySecurity, instead of being looked up by name.
+	modtime    bool
+	valuecount bool
+	security   bool
 }
 
 func fieldToFieldInfo(field reflect.StructField) *fieldInfo {
@@ -42,6 +52,18 @@ func fieldToFieldInfo(field reflect.StructField) *fieldInfo {
 		if component == "required" {
 			fi.required = true
 		}
+		if component == "expand" {
+			fi.expand = true
+		}
+		if component == "modtime" {
+			fi.modtime = true
+		}
+		if component == "valuecount" {
+			fi.valuecount = true
+		}
+		if component == "security" {
+			fi.security = true
+		}
 	}
 	fi.anonymous = field.Anonymous
 	return fi
@@ -49,10 +71,67 @@ func fieldToFieldInfo(field reflect.StructField) *fieldInfo {
 
 type registryEntry interface {
 	unmarshal(reflect.Value) error
+	marshal(reflect.Value, syscall.Handle) error
 	fieldInfo() *fieldInfo
 	populate(syscall.Handle) error
 }
 
+// Unmarshaler is implemented by types that want to decode a registry value
+// themselves rather than going through the built-in kind conversions. It's
+// checked before those conversions run, so it can be used to read formats
+// the library has no native notion of, e.g. a SID or FILETIME packed into
+// a REG_BINARY value.
+type Unmarshaler interface {
+	UnmarshalRegistryValue(kind uint32, data []byte) error
+}
+
+// Marshaler is the symmetric counterpart to Unmarshaler, used by Marshal
+// and (*Decoder).Encode to produce the raw value written via RegSetValueEx.
+type Marshaler interface {
+	MarshalRegistryValue() (kind uint32, data []byte, err error)
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+)
+
+func unmarshalerFor(val reflect.Value) (Unmarshaler, bool) {
+	if val.Kind() == reflect.Ptr {
+		if !val.Type().Implements(unmarshalerType) {
+			return nil, false
+		}
+		if val.IsNil() && val.CanSet() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return val.Interface().(Unmarshaler), true
+	}
+	if val.CanAddr() {
+		if pv := val.Addr(); pv.Type().Implements(unmarshalerType) {
+			return pv.Interface().(Unmarshaler), true
+		}
+	}
+	if val.Type().Implements(unmarshalerType) {
+		return val.Interface().(Unmarshaler), true
+	}
+	return nil, false
+}
+
+func marshalerFor(origVal, val reflect.Value) (Marshaler, bool) {
+	if origVal.Kind() == reflect.Ptr && origVal.Type().Implements(marshalerType) {
+		return origVal.Interface().(Marshaler), true
+	}
+	if val.CanAddr() {
+		if pv := val.Addr(); pv.Type().Implements(marshalerType) {
+			return pv.Interface().(Marshaler), true
+		}
+	}
+	if val.Type().Implements(marshalerType) {
+		return val.Interface().(Marshaler), true
+	}
+	return nil, false
+}
+
 type registryValue struct {
 	parent syscall.Handle
 
@@ -77,6 +156,16 @@ type registryKey struct {
 	skip bool
 
 	subentries []registryEntry
+
+	// Metadata fields (registry:",modtime" etc.), populated directly from
+	// this key's own handle rather than looked up as a named subentry.
+	modtimeField    *fieldInfo
+	valuecountField *fieldInfo
+	securityField   *fieldInfo
+
+	modtime    time.Time
+	valuecount uint32
+	security   []byte
 }
 
 func (rk *registryKey) fieldInfo() *fieldInfo {
@@ -90,22 +179,17 @@ func Parse(u string, i interface{}) error {
 		return errors.New("registry: cannot unmarshal into non-struct")
 	}
 
-	regUrl, err := url.Parse(u)
+	server, path, rootHkey, err := parseRegistryURL(u)
 	if err != nil {
 		return err
 	}
 
-	rootHkey := syscall.Handle(0)
-	switch strings.ToLower(regUrl.Host) {
-	case "hkcu":
-		rootHkey = syscall.HKEY_CURRENT_USER
-	case "hklm":
-		rootHkey = syscall.HKEY_LOCAL_MACHINE
-	default:
-		return fmt.Errorf("registry: unknown root key '%s'", regUrl.Host)
+	rootHkey, closeRoot, err := connectRoot(server, rootHkey)
+	if err != nil {
+		return err
 	}
+	defer closeRoot()
 
-	path := strings.Replace(regUrl.Path[1:], "/", `\`, -1)
 	ent := entryFor(rval.Type(), path, &fieldInfo{required: true})
 	err = ent.populate(rootHkey)
 	if err != nil {
@@ -120,6 +204,108 @@ func Parse(u string, i interface{}) error {
 	return nil
 }
 
+// Marshal writes i, a struct (or pointer to one) tagged the same way as
+// Parse's destination, back to the registry at u. Keys and values that
+// don't yet exist are created; existing ones are overwritten.
+func Marshal(u string, i interface{}) error {
+	rval := reflect.ValueOf(i)
+	if rval.Kind() == reflect.Ptr {
+		rval = rval.Elem()
+	}
+	if rval.Kind() != reflect.Struct {
+		return errors.New("registry: cannot marshal non-struct")
+	}
+
+	server, path, rootHkey, err := parseRegistryURL(u)
+	if err != nil {
+		return err
+	}
+
+	rootHkey, closeRoot, err := connectRoot(server, rootHkey)
+	if err != nil {
+		return err
+	}
+	defer closeRoot()
+
+	ent := entryFor(rval.Type(), path, &fieldInfo{required: true})
+	return ent.marshal(rval, rootHkey)
+}
+
+func rootKeyFromName(name string) (syscall.Handle, error) {
+	switch strings.ToLower(name) {
+	case "hkcr":
+		return syscall.HKEY_CLASSES_ROOT, nil
+	case "hkcu":
+		return syscall.HKEY_CURRENT_USER, nil
+	case "hklm":
+		return syscall.HKEY_LOCAL_MACHINE, nil
+	case "hku":
+		return syscall.HKEY_USERS, nil
+	case "hkcc":
+		return syscall.HKEY_CURRENT_CONFIG, nil
+	case "hkpd":
+		return syscall.HKEY_PERFORMANCE_DATA, nil
+	default:
+		return 0, fmt.Errorf("registry: unknown root key '%s'", name)
+	}
+}
+
+// parseRegistryURL decodes a registry: URL into the remote machine to
+// connect to (empty for the local machine), the backslash-separated key
+// path, and the predefined root handle to resolve it under.
+//
+// The common, local form is registry://hklm/Software/Foo, where the host
+// component names the root. To address a remote machine, put its name in
+// the host component instead and lead the path with the root, e.g.
+// registry://SERVER/hklm/Software/Foo.
+func parseRegistryURL(u string) (server string, path string, rootHkey syscall.Handle, err error) {
+	regUrl, err := url.Parse(u)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	fullPath := strings.Replace(regUrl.Path[1:], "/", `\`, -1)
+
+	if rootHkey, err = rootKeyFromName(regUrl.Host); err == nil {
+		return "", fullPath, rootHkey, nil
+	}
+
+	parts := strings.SplitN(fullPath, `\`, 2)
+	rootHkey, err = rootKeyFromName(parts[0])
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	path = ""
+	if len(parts) > 1 {
+		path = parts[1]
+	}
+	return regUrl.Host, path, rootHkey, nil
+}
+
+// connectRoot turns a predefined root handle into one usable as the
+// parent of RegOpenKeyEx/RegCreateKeyEx. For the local machine (server ==
+// "") that's just rootHkey itself and the returned close func is a no-op;
+// for a remote machine it's the handle returned by RegConnectRegistry,
+// which must be closed with RegCloseKey once the caller is done with it.
+func connectRoot(server string, rootHkey syscall.Handle) (syscall.Handle, func(), error) {
+	if server == "" {
+		return rootHkey, func() {}, nil
+	}
+
+	serverU16, err := syscall.UTF16PtrFromString(server)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var remoteHkey syscall.Handle
+	if err := RegConnectRegistry(serverU16, rootHkey, &remoteHkey); err != nil {
+		return 0, nil, fmt.Errorf("registry: could not connect to '%s': %v", server, err)
+	}
+
+	return remoteHkey, func() { syscall.RegCloseKey(remoteHkey) }, nil
+}
+
 func entryFor(typ reflect.Type, path string, fi *fieldInfo) registryEntry {
 	if fi == nil {
 		fi = &fieldInfo{}
@@ -129,6 +315,10 @@ func entryFor(typ reflect.Type, path string, fi *fieldInfo) registryEntry {
 	}
 
 	if typ.Kind() == reflect.Struct {
+		rk := &registryKey{
+			field: fi,
+			path:  path,
+		}
 		subentries := make([]registryEntry, 0, 16)
 		for i := 0; i < typ.NumField(); i++ {
 			field := typ.Field(i)
@@ -136,12 +326,23 @@ func entryFor(typ reflect.Type, path string, fi *fieldInfo) registryEntry {
 			if newFi == nil {
 				continue
 			}
-			subentries = append(subentries, entryFor(typ.Field(i).Type, newFi.name, newFi))
+			switch {
+			case newFi.modtime:
+				rk.modtimeField = newFi
+			case newFi.valuecount:
+				rk.valuecountField = newFi
+			case newFi.security:
+				rk.securityField = newFi
+			default:
+				subentries = append(subentries, entryFor(typ.Field(i).Type, newFi.name, newFi))
+			}
 		}
-		return &registryKey{
-			field:      fi,
-			path:       path,
-			subentries: subentries,
+		rk.subentries = subentries
+		return rk
+	} else if typ.Kind() == reflect.Map {
+		return &registryMap{
+			field:    fi,
+			elemType: typ.Elem(),
 		}
 	} else {
 		return &registryValue{
@@ -179,6 +380,10 @@ func (rk *registryKey) populate(parent syscall.Handle) error {
 		rk.hkey = parent
 	}
 
+	if err := rk.populateMetadata(); err != nil {
+		return err
+	}
+
 	for _, entry := range rk.subentries {
 		err := entry.populate(rk.hkey)
 		if err != nil {
@@ -188,6 +393,89 @@ func (rk *registryKey) populate(parent syscall.Handle) error {
 	return nil
 }
 
+func (rk *registryKey) populateMetadata() error {
+	if rk.modtimeField != nil || rk.valuecountField != nil {
+		var valuesLen uint32
+		var lastWrite syscall.Filetime
+		err := syscall.RegQueryInfoKey(rk.hkey, nil, nil, nil, nil, nil, nil, &valuesLen, nil, nil, nil, &lastWrite)
+		if err != nil {
+			return fmt.Errorf("registry: could not query key info for '%s': %v", rk.path, err)
+		}
+		rk.valuecount = valuesLen
+		rk.modtime = time.Unix(0, lastWrite.Nanoseconds())
+	}
+
+	if rk.securityField != nil {
+		sd, err := rk.querySecurity()
+		if err != nil {
+			return fmt.Errorf("registry: could not query key security for '%s': %v", rk.path, err)
+		}
+		rk.security = sd
+	}
+
+	return nil
+}
+
+func (rk *registryKey) querySecurity() ([]byte, error) {
+	const flags = ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation
+
+	var sdLen uint32
+	err := RegGetKeySecurity(rk.hkey, flags, nil, &sdLen)
+	if err != nil && err != errInsufficientBuffer {
+		return nil, err
+	}
+
+	sd := make([]byte, sdLen)
+	if err := RegGetKeySecurity(rk.hkey, flags, &sd[0], &sdLen); err != nil {
+		return nil, err
+	}
+	return sd, nil
+}
+
+func (rk *registryKey) marshal(val reflect.Value, parent syscall.Handle) error {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			if rk.field.required {
+				return fmt.Errorf("registry: required key '%s' is nil", rk.path)
+			}
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	hkey := parent
+	if !rk.field.anonymous {
+		pathU16, err := syscall.UTF16PtrFromString(rk.path)
+		if err != nil {
+			panic(err)
+		}
+
+		var disposition uint32
+		err = RegCreateKeyEx(parent, pathU16, 0, nil, 0, syscall.KEY_ALL_ACCESS, nil, &hkey, &disposition)
+		if err != nil {
+			if rk.field.required {
+				return fmt.Errorf("registry: required key '%s' could not be created.", rk.path)
+			}
+			return nil
+		}
+
+		defer func() {
+			err = syscall.RegCloseKey(hkey)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	for _, entry := range rk.subentries {
+		newVal := val.FieldByIndex(entry.fieldInfo().index)
+		if err := entry.marshal(newVal, hkey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (rv *registryValue) populate(parent syscall.Handle) error {
 	nameU16, err := syscall.UTF16PtrFromString(rv.field.name)
 	if err != nil {
@@ -215,6 +503,95 @@ func (rv *registryValue) populate(parent syscall.Handle) error {
 	return nil
 }
 
+func (rv *registryValue) marshal(val reflect.Value, parent syscall.Handle) error {
+	origVal := val
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			if rv.field.required {
+				return fmt.Errorf("registry: required value '%s' is nil", rv.field.name)
+			}
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	var data []byte
+	var kind uint32
+
+	if m, ok := marshalerFor(origVal, val); ok {
+		k, d, err := m.MarshalRegistryValue()
+		if err != nil {
+			return err
+		}
+		return rv.setValue(parent, k, d)
+	}
+
+	switch val.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		kind = syscall.REG_DWORD
+		data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(val.Uint()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		kind = syscall.REG_DWORD
+		data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(val.Int()))
+	case reflect.Uint64:
+		kind = syscall.REG_QWORD
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, val.Uint())
+	case reflect.Int64:
+		kind = syscall.REG_QWORD
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(val.Int()))
+	case reflect.String:
+		kind = syscall.REG_SZ
+		if rv.field.expand {
+			kind = syscall.REG_EXPAND_SZ
+		}
+		data = utf8ToUTF16Bytes(val.String())
+	case reflect.Slice:
+		switch val.Type().Elem().Kind() {
+		case reflect.Uint8:
+			kind = syscall.REG_BINARY
+			data = val.Bytes()
+		case reflect.String:
+			kind = syscall.REG_MULTI_SZ
+			var buf bytes.Buffer
+			for i := 0; i < val.Len(); i++ {
+				buf.Write(utf8ToUTF16Bytes(val.Index(i).String()))
+			}
+			buf.Write([]byte{0, 0})
+			data = buf.Bytes()
+		default:
+			return fmt.Errorf("registry: don't know how to marshal value '%s' of slice type %v", rv.field.name, val.Type())
+		}
+	default:
+		return fmt.Errorf("registry: don't know how to marshal value '%s' of type %v", rv.field.name, val.Type())
+	}
+
+	return rv.setValue(parent, kind, data)
+}
+
+func (rv *registryValue) setValue(parent syscall.Handle, kind uint32, data []byte) error {
+	nameU16, err := syscall.UTF16PtrFromString(rv.field.name)
+	if err != nil {
+		return err
+	}
+
+	var dataPtr *byte
+	if len(data) > 0 {
+		dataPtr = &data[0]
+	}
+
+	err = RegSetValueEx(parent, nameU16, 0, kind, dataPtr, uint32(len(data)))
+	if err != nil {
+		if rv.field.required {
+			return fmt.Errorf("registry: required value '%s' could not be set.", rv.field.name)
+		}
+	}
+	return nil
+}
+
 func (rk *registryKey) unmarshal(val reflect.Value) error {
 	if rk.skip {
 		return nil
@@ -234,6 +611,153 @@ func (rk *registryKey) unmarshal(val reflect.Value) error {
 			return err
 		}
 	}
+
+	if rk.modtimeField != nil {
+		val.FieldByIndex(rk.modtimeField.index).Set(reflect.ValueOf(rk.modtime))
+	}
+	if rk.valuecountField != nil {
+		val.FieldByIndex(rk.valuecountField.index).SetInt(int64(rk.valuecount))
+	}
+	if rk.securityField != nil {
+		val.FieldByIndex(rk.securityField.index).SetBytes(rk.security)
+	}
+	return nil
+}
+
+// registryMap backs a struct field of kind map[string]T (or map[string]*T).
+// Rather than opening a named subkey or value, it enumerates everything
+// present under its parent: if T is a struct it enumerates subkeys via
+// RegEnumKeyEx, otherwise it enumerates values via RegEnumValue.
+type registryMap struct {
+	field    *fieldInfo
+	elemType reflect.Type
+
+	skip bool
+
+	values  map[string]*registryValue
+	subkeys map[string]registryEntry
+}
+
+func (rm *registryMap) fieldInfo() *fieldInfo {
+	return rm.field
+}
+
+func (rm *registryMap) isSubkeyMap() bool {
+	elemTyp := rm.elemType
+	if elemTyp.Kind() == reflect.Ptr {
+		elemTyp = elemTyp.Elem()
+	}
+	return elemTyp.Kind() == reflect.Struct
+}
+
+func (rm *registryMap) populate(parent syscall.Handle) error {
+	if rm.isSubkeyMap() {
+		return rm.populateSubkeys(parent)
+	}
+	return rm.populateValues(parent)
+}
+
+func (rm *registryMap) populateValues(parent syscall.Handle) error {
+	rm.values = make(map[string]*registryValue)
+
+	for index := uint32(0); ; index++ {
+		nameBuf := make([]uint16, syscall.MAX_LONG_PATH)
+		nameLen := uint32(len(nameBuf))
+		err := RegEnumValue(parent, index, &nameBuf[0], &nameLen, nil, nil, nil, nil)
+		if err == ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			if rm.field.required {
+				return fmt.Errorf("registry: could not enumerate values: %v", err)
+			}
+			rm.skip = true
+			return nil
+		}
+
+		name := syscall.UTF16ToString(nameBuf[:nameLen])
+		rv := &registryValue{field: &fieldInfo{name: name}, kind: -1}
+		if err := rv.populate(parent); err != nil {
+			return err
+		}
+		rm.values[name] = rv
+	}
+	return nil
+}
+
+func (rm *registryMap) populateSubkeys(parent syscall.Handle) error {
+	rm.subkeys = make(map[string]registryEntry)
+
+	// RegEnumKeyEx's own docs require successive calls to stay on the
+	// same OS thread; without this, a goroutine reschedule mid-enumeration
+	// can silently skip or duplicate subkeys.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	for index := uint32(0); ; index++ {
+		nameBuf := make([]uint16, syscall.MAX_LONG_PATH)
+		nameLen := uint32(len(nameBuf))
+		err := syscall.RegEnumKeyEx(parent, index, &nameBuf[0], &nameLen, nil, nil, nil, nil)
+		if err == ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if err != nil {
+			if rm.field.required {
+				return fmt.Errorf("registry: could not enumerate subkeys: %v", err)
+			}
+			rm.skip = true
+			return nil
+		}
+
+		name := syscall.UTF16ToString(nameBuf[:nameLen])
+		ent := entryFor(rm.elemType, name, &fieldInfo{name: name})
+		if err := ent.populate(parent); err != nil {
+			return err
+		}
+		rm.subkeys[name] = ent
+	}
+	return nil
+}
+
+func (rm *registryMap) unmarshal(val reflect.Value) error {
+	if rm.skip {
+		return nil
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	mapType := val.Type()
+	val.Set(reflect.MakeMap(mapType))
+
+	if rm.isSubkeyMap() {
+		for name, ent := range rm.subkeys {
+			elemVal := reflect.New(mapType.Elem()).Elem()
+			if err := ent.unmarshal(elemVal); err != nil {
+				return err
+			}
+			val.SetMapIndex(reflect.ValueOf(name), elemVal)
+		}
+		return nil
+	}
+
+	for name, rv := range rm.values {
+		elemVal := reflect.New(mapType.Elem()).Elem()
+		if err := rv.unmarshal(elemVal); err != nil {
+			return err
+		}
+		val.SetMapIndex(reflect.ValueOf(name), elemVal)
+	}
+	return nil
+}
+
+// marshal is not yet supported for registryMap: writing an arbitrary set
+// of enumerated values/subkeys back is a bigger change than this chunk
+// covers, so map fields are simply skipped on Marshal/Encode.
+func (rm *registryMap) marshal(val reflect.Value, parent syscall.Handle) error {
 	return nil
 }
 
@@ -251,6 +775,10 @@ func (rv *registryValue) unmarshal(val reflect.Value) error {
 		return nil
 	}
 
+	if u, ok := unmarshalerFor(val); ok {
+		return u.UnmarshalRegistryValue(uint32(rv.kind), rv.data)
+	}
+
 	var newKind int = kindUnknown
 	var x interface{}
 	switch rv.kind {
@@ -349,3 +877,14 @@ func utf16BytesToUTF8(b []byte) []rune {
 	}
 	return utf16.Decode(utf)
 }
+
+// utf8ToUTF16Bytes encodes s as a NUL-terminated, little-endian UTF-16
+// byte string, the inverse of utf16BytesToUTF8.
+func utf8ToUTF16Bytes(s string) []byte {
+	u16 := utf16.Encode([]rune(s))
+	b := make([]byte, (len(u16)+1)*2)
+	for i, v := range u16 {
+		binary.LittleEndian.PutUint16(b[i*2:], v)
+	}
+	return b
+}
@@ -0,0 +1,193 @@
+// +build windows
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// REG_NOTIFY_CHANGE_* filters for RegNotifyChangeKeyValue. The standard
+// library doesn't define these.
+const (
+	regNotifyChangeName    = 0x00000001
+	regNotifyChangeLastSet = 0x00000004
+)
+
+// Watch performs an initial Decode into i and then keeps it current: every
+// key visited while decoding is watched via RegNotifyChangeKeyValue, and
+// any change causes the whole struct to be re-decoded under a mutex, with
+// the result (nil, or a decode error) sent on the returned channel.
+// Cancelling ctx stops all watches and closes the channel.
+//
+// Watch re-decodes the entire struct on every notification rather than
+// just the subtree that changed; that's simple and correct; it's worth
+// knowing if i is large and changes are frequent.
+func (d *Decoder) Watch(ctx context.Context, i interface{}) (<-chan error, error) {
+	rval := reflect.ValueOf(i)
+
+	// Build and populate the entry tree ourselves, rather than calling
+	// d.Decode, so collectWatchPaths below sees the real rk.skip state
+	// left behind by populate: a fresh, unpopulated tree would report
+	// every optional subkey as present and watch it regardless of
+	// whether it actually exists.
+	ent := entryFor(rval.Type(), "", &fieldInfo{required: true, anonymous: true})
+	if err := ent.populate(d.hkey); err != nil {
+		return nil, err
+	}
+	if rval.Kind() == reflect.Ptr {
+		rval = rval.Elem()
+	}
+	if err := ent.unmarshal(rval); err != nil {
+		return nil, err
+	}
+
+	paths := uniqueStrings(collectWatchPaths(ent, ""))
+
+	ch := make(chan error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			watchKey(ctx, d.hkey, rval, path, &mu, ch)
+		}(path)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func redecodeInto(root syscall.Handle, rval reflect.Value) error {
+	ent := entryFor(rval.Type(), "", &fieldInfo{required: true, anonymous: true})
+	if err := ent.populate(root); err != nil {
+		return err
+	}
+	return ent.unmarshal(rval)
+}
+
+// collectWatchPaths walks the entry tree built by entryFor and returns the
+// full, root-relative path of every key it visited (skipped keys excluded).
+func collectWatchPaths(ent registryEntry, prefix string) []string {
+	rk, ok := ent.(*registryKey)
+	if !ok || rk.skip {
+		return nil
+	}
+
+	full := prefix
+	if !rk.field.anonymous {
+		if full == "" {
+			full = rk.path
+		} else {
+			full = full + `\` + rk.path
+		}
+	}
+
+	paths := []string{full}
+	for _, sub := range rk.subentries {
+		paths = append(paths, collectWatchPaths(sub, full)...)
+	}
+	return paths
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	return out
+}
+
+// watchKey opens path under root, then alternates RegNotifyChangeKeyValue
+// and WaitForSingleObject until ctx is cancelled or an error occurs,
+// re-decoding rval (guarded by mu) and sending the result on ch each time
+// a change fires.
+func watchKey(ctx context.Context, root syscall.Handle, rval reflect.Value, path string, mu *sync.Mutex, ch chan<- error) {
+	pathU16, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		sendOrDone(ctx, ch, err)
+		return
+	}
+
+	var hkey syscall.Handle
+	if err := syscall.RegOpenKeyEx(root, pathU16, 0, syscall.KEY_NOTIFY|syscall.KEY_READ, &hkey); err != nil {
+		sendOrDone(ctx, ch, fmt.Errorf("registry: could not open '%s' to watch it: %v", path, err))
+		return
+	}
+	defer syscall.RegCloseKey(hkey)
+
+	event, err := CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		sendOrDone(ctx, ch, fmt.Errorf("registry: could not create a wait event for '%s': %v", path, err))
+		return
+	}
+	// Closing the event unblocks the WaitForSingleObject below, which is
+	// how cancellation interrupts an otherwise indefinite wait. It's also
+	// how every other exit from this function releases the event, so a
+	// sync.Once guards against closing the same handle value twice (the
+	// ctx-cancellation goroutine and a normal return could otherwise
+	// race to close it, and a double-close can silently take out an
+	// unrelated handle the OS has since recycled onto that value).
+	var closeEventOnce sync.Once
+	closeEvent := func() { closeEventOnce.Do(func() { syscall.CloseHandle(event) }) }
+	defer closeEvent()
+
+	go func() {
+		<-ctx.Done()
+		closeEvent()
+	}()
+
+	for {
+		err := RegNotifyChangeKeyValue(hkey, true, regNotifyChangeName|regNotifyChangeLastSet, event, true)
+		if err != nil {
+			sendOrDone(ctx, ch, fmt.Errorf("registry: could not watch '%s': %v", path, err))
+			return
+		}
+
+		if _, err := syscall.WaitForSingleObject(event, syscall.INFINITE); err != nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// event is manual-reset, so it stays signaled until we reset it
+		// here; without this, every subsequent wait above would return
+		// immediately and the loop would busy-spin re-decoding.
+		if err := ResetEvent(event); err != nil {
+			sendOrDone(ctx, ch, fmt.Errorf("registry: could not reset wait event for '%s': %v", path, err))
+			return
+		}
+
+		mu.Lock()
+		decodeErr := redecodeInto(root, rval)
+		mu.Unlock()
+
+		if !sendOrDone(ctx, ch, decodeErr) {
+			return
+		}
+	}
+}
+
+func sendOrDone(ctx context.Context, ch chan<- error, err error) bool {
+	select {
+	case ch <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+// +build windows
+
+package registry
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// The standard library's syscall package only exposes a read-side subset of
+// the Win32 registry API (RegOpenKeyEx, RegQueryValueEx, ...). The calls
+// below round that out with the write-side equivalents we need; they're
+// thin Syscall wrappers in the same style as syscall's own zsyscall_windows.go.
+var (
+	modadvapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procRegCreateKeyExW         = modadvapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW          = modadvapi32.NewProc("RegSetValueExW")
+	procRegEnumValueW           = modadvapi32.NewProc("RegEnumValueW")
+	procRegConnectRegistryW     = modadvapi32.NewProc("RegConnectRegistryW")
+	procRegGetKeySecurity       = modadvapi32.NewProc("RegGetKeySecurity")
+	procRegNotifyChangeKeyValue = modadvapi32.NewProc("RegNotifyChangeKeyValue")
+
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW = modkernel32.NewProc("CreateEventW")
+	procResetEvent   = modkernel32.NewProc("ResetEvent")
+)
+
+// ERROR_NO_MORE_ITEMS is returned by RegEnumValue/RegEnumKeyEx once the
+// index passed in is past the last subkey or value.
+const ERROR_NO_MORE_ITEMS syscall.Errno = 259
+
+// errInsufficientBuffer is returned by RegGetKeySecurity when the caller's
+// buffer is too small; it's also how we ask for the required size.
+const errInsufficientBuffer syscall.Errno = 122
+
+// SECURITY_INFORMATION flags for RegGetKeySecurity; see the Win32
+// SECURITY_INFORMATION documentation.
+const (
+	ownerSecurityInformation = 0x00000001
+	groupSecurityInformation = 0x00000002
+	daclSecurityInformation  = 0x00000004
+)
+
+func RegCreateKeyEx(key syscall.Handle, subkey *uint16, reserved uint32, class *uint16, options uint32, desiredAccess uint32, sa *syscall.SecurityAttributes, result *syscall.Handle, disposition *uint32) error {
+	r0, _, _ := syscall.Syscall9(procRegCreateKeyExW.Addr(), 9,
+		uintptr(key),
+		uintptr(unsafe.Pointer(subkey)),
+		uintptr(reserved),
+		uintptr(unsafe.Pointer(class)),
+		uintptr(options),
+		uintptr(desiredAccess),
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(unsafe.Pointer(result)),
+		uintptr(unsafe.Pointer(disposition)))
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
+
+func RegSetValueEx(key syscall.Handle, name *uint16, reserved uint32, valtype uint32, data *byte, dataLen uint32) error {
+	r0, _, _ := syscall.Syscall6(procRegSetValueExW.Addr(), 6,
+		uintptr(key),
+		uintptr(unsafe.Pointer(name)),
+		uintptr(reserved),
+		uintptr(valtype),
+		uintptr(unsafe.Pointer(data)),
+		uintptr(dataLen))
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
+
+func RegConnectRegistry(machineName *uint16, key syscall.Handle, result *syscall.Handle) error {
+	r0, _, _ := syscall.Syscall(procRegConnectRegistryW.Addr(), 3,
+		uintptr(unsafe.Pointer(machineName)),
+		uintptr(key),
+		uintptr(unsafe.Pointer(result)))
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
+
+func RegGetKeySecurity(key syscall.Handle, securityInformation uint32, sd *byte, sdLen *uint32) error {
+	r0, _, _ := syscall.Syscall6(procRegGetKeySecurity.Addr(), 4,
+		uintptr(key),
+		uintptr(securityInformation),
+		uintptr(unsafe.Pointer(sd)),
+		uintptr(unsafe.Pointer(sdLen)),
+		0, 0)
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
+
+func RegNotifyChangeKeyValue(key syscall.Handle, watchSubtree bool, notifyFilter uint32, event syscall.Handle, async bool) error {
+	var watchSubtreeArg, asyncArg uintptr
+	if watchSubtree {
+		watchSubtreeArg = 1
+	}
+	if async {
+		asyncArg = 1
+	}
+
+	r0, _, _ := syscall.Syscall6(procRegNotifyChangeKeyValue.Addr(), 5,
+		uintptr(key),
+		watchSubtreeArg,
+		uintptr(notifyFilter),
+		uintptr(event),
+		asyncArg,
+		0)
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
+
+// CreateEvent wraps the Win32 CreateEventW API, which the standard
+// library's syscall package does not expose.
+func CreateEvent(sa *syscall.SecurityAttributes, manualReset, initialState uint32, name *uint16) (syscall.Handle, error) {
+	r0, _, e1 := syscall.Syscall6(procCreateEventW.Addr(), 4,
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(manualReset),
+		uintptr(initialState),
+		uintptr(unsafe.Pointer(name)),
+		0, 0)
+	if r0 == 0 {
+		if e1 != 0 {
+			return 0, e1
+		}
+		return 0, syscall.EINVAL
+	}
+	return syscall.Handle(r0), nil
+}
+
+// ResetEvent wraps the Win32 ResetEvent API, used to clear a manual-reset
+// event between waits.
+func ResetEvent(event syscall.Handle) error {
+	r0, _, e1 := syscall.Syscall(procResetEvent.Addr(), 1, uintptr(event), 0, 0)
+	if r0 == 0 {
+		if e1 != 0 {
+			return e1
+		}
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+func RegEnumValue(key syscall.Handle, index uint32, name *uint16, nameLen *uint32, reserved *uint32, valtype *uint32, data *byte, dataLen *uint32) error {
+	r0, _, _ := syscall.Syscall9(procRegEnumValueW.Addr(), 8,
+		uintptr(key),
+		uintptr(index),
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(nameLen)),
+		uintptr(unsafe.Pointer(reserved)),
+		uintptr(unsafe.Pointer(valtype)),
+		uintptr(unsafe.Pointer(data)),
+		uintptr(unsafe.Pointer(dataLen)),
+		0)
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}